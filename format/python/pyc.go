@@ -4,6 +4,8 @@ package pyc
 
 import (
 	"embed"
+	"math/big"
+	"strconv"
 	"time"
 
 	"github.com/wader/fq/format"
@@ -106,18 +108,80 @@ var typeMap = scalar.UintMap{
 	TYPE_REF: {Sym: "ref", Description: "Reference to an earlier object"},
 }
 
-func read_list(d *decode.D, n int64) {
+// refTracked lists the types that CPython's r_ref/r_ref_reserve always
+// register in the reference table, independent of whether FLAG_REF was
+// set on the type byte.
+var refTracked = map[uint64]bool{
+	TYPE_TUPLE:                true,
+	TYPE_SMALL_TUPLE:          true,
+	TYPE_LIST:                 true,
+	TYPE_DICT:                 true,
+	TYPE_SET:                  true,
+	TYPE_FROZENSET:            true,
+	TYPE_CODE:                 true,
+	TYPE_UNICODE:              true,
+	TYPE_INTERNED:             true,
+	TYPE_ASCII:                true,
+	TYPE_ASCII_INTERNED:       true,
+	TYPE_SHORT_ASCII:          true,
+	TYPE_SHORT_ASCII_INTERNED: true,
+	TYPE_LONG:                 true,
+	TYPE_FLOAT:                true,
+	TYPE_BINARY_FLOAT:         true,
+	TYPE_COMPLEX:              true,
+	TYPE_BINARY_COMPLEX:       true,
+}
+
+// refEntry records an object that was added to the reference table so a
+// later TYPE_REF can point back to it. value is filled in once the
+// object has finished decoding.
+type refEntry struct {
+	pos   int64
+	ty    uint64
+	value any
+}
+
+// decodeCtx carries state that needs to be threaded through the
+// recursive r_object calls for a single top-level marshal stream.
+type decodeCtx struct {
+	refs []refEntry
+	// pyVersion is the symbolic version derived from the file magic
+	// (e.g. "3.11.0"), or "" if the magic wasn't recognized.
+	pyVersion string
+	// pyMinor is the CPython 3.x minor version, used to pick the right
+	// opcode table and gate other version-specific decoding. Defaults to
+	// the newest supported table when the magic isn't recognized.
+	pyMinor int
+}
+
+// read_list decodes n objects and returns their values, in order, for
+// callers that need to look inside a tuple/list (e.g. co_consts).
+func read_list(d *decode.D, ctx *decodeCtx, n int64) []any {
+	items := make([]any, 0, n)
 	d.FieldStructNArray("items", "item", n, func(d *decode.D) {
-		r_object(d)
+		_, value := r_object(d, ctx)
+		items = append(items, value)
 	})
+	return items
 }
 
-func r_object(d *decode.D) uint64 {
+func r_object(d *decode.D, ctx *decodeCtx) (uint64, any) {
+	objPos := d.Pos()
+
+	var rawCode uint64
 	ty := d.FieldUintFn("type", func(d *decode.D) uint64 {
-		code := d.U8()
-		return code & ^FLAG_REF
+		rawCode = d.U8()
+		return rawCode & ^FLAG_REF
 	}, typeMap)
 
+	refIndex := -1
+	if rawCode&FLAG_REF != 0 || refTracked[ty] {
+		refIndex = len(ctx.refs)
+		ctx.refs = append(ctx.refs, refEntry{pos: objPos, ty: ty})
+	}
+
+	var value any
+
 	switch ty {
 	// NO DATA
 	case TYPE_NULL:
@@ -125,29 +189,61 @@ func r_object(d *decode.D) uint64 {
 	case TYPE_STOPITER:
 	case TYPE_ELLIPSIS:
 	case TYPE_FALSE:
+		value = false
 	case TYPE_TRUE:
+		value = true
 	// NO DATA
 
 	case TYPE_INT:
-		d.FieldS32("value")
+		value = d.FieldS32("value")
 	case TYPE_INT64:
-		d.FieldS64("value")
+		value = d.FieldS64("value")
 	case TYPE_LONG:
-		panic("long not implemented")
+		n := d.FieldS32("n")
+		nDigits := n
+		if nDigits < 0 {
+			nDigits = -nDigits
+		}
+		bigValue := new(big.Int)
+		if nDigits > 0 {
+			d.FieldArray("digits", func(d *decode.D) {
+				for i := int64(0); i < nDigits; i++ {
+					digit := d.FieldU16("digit")
+					bigValue.Add(bigValue, new(big.Int).Lsh(new(big.Int).SetUint64(digit), uint(15*i)))
+				}
+			})
+		}
+		if n < 0 {
+			bigValue.Neg(bigValue)
+		}
+		d.FieldValueBigInt("value", bigValue)
+		value = bigValue
 
 	case TYPE_FLOAT:
-		panic("float not implemented")
-		// Seems to not be used any more?
-		// d.TryFieldAnyFn("value", func(d *decode.D) (any, error) {
-		// 	s := d.UTF8ShortString()
-		// 	return strconv.ParseFloat(s, 64)
-		// })
+		// Generated for version 0 only: a length-prefixed ASCII repr of the float.
+		value = d.TryFieldAnyFn("value", func(d *decode.D) (any, error) {
+			s := d.UTF8ShortString()
+			return strconv.ParseFloat(s, 64)
+		})
 
 	case TYPE_BINARY_FLOAT:
-		d.FieldF64("value")
+		value = d.FieldF64("value")
 
 	case TYPE_COMPLEX:
-		panic("complex not implemented")
+		// Generated for version 0 only: real and imag as TYPE_FLOAT-style strings.
+		realValue := d.TryFieldAnyFn("real", func(d *decode.D) (any, error) {
+			s := d.UTF8ShortString()
+			return strconv.ParseFloat(s, 64)
+		})
+		imagValue := d.TryFieldAnyFn("imag", func(d *decode.D) (any, error) {
+			s := d.UTF8ShortString()
+			return strconv.ParseFloat(s, 64)
+		})
+		if re, ok := realValue.(float64); ok {
+			if im, ok := imagValue.(float64); ok {
+				value = complex(re, im)
+			}
+		}
 
 	case TYPE_BINARY_COMPLEX:
 		d.FieldF64("real")
@@ -155,49 +251,52 @@ func r_object(d *decode.D) uint64 {
 
 	case TYPE_STRING:
 		length := d.FieldS32("length")
+		bytes := d.BytesLen(int(length))
+		d.SeekRel(-length * 8)
 		d.FieldRawLen("value", length*8)
+		value = bytes
 
 	case TYPE_ASCII_INTERNED:
 		fallthrough
 	case TYPE_ASCII:
 		length := d.FieldS32("length")
-		d.FieldStr("value", int(length), unicode.UTF8)
+		value = d.FieldStr("value", int(length), unicode.UTF8)
 
 	case TYPE_SHORT_ASCII_INTERNED:
-		d.FieldUTF8ShortString("string")
+		value = d.FieldUTF8ShortString("string")
 	case TYPE_SHORT_ASCII:
-		d.FieldUTF8ShortString("string")
+		value = d.FieldUTF8ShortString("string")
 
 	case TYPE_INTERNED:
 		fallthrough
 	case TYPE_UNICODE:
 		length := d.FieldS32("length")
-		d.FieldStr("value", int(length), unicode.UTF8)
+		value = d.FieldStr("value", int(length), unicode.UTF8)
 
 	case TYPE_SMALL_TUPLE:
 		n := d.FieldU8("n")
-		read_list(d, int64(n))
+		value = read_list(d, ctx, int64(n))
 	case TYPE_TUPLE:
 		n := d.FieldS32("n")
-		read_list(d, int64(n))
+		value = read_list(d, ctx, int64(n))
 
 	case TYPE_LIST:
 		n := d.FieldS32("n")
-		read_list(d, int64(n))
+		value = read_list(d, ctx, int64(n))
 
 	case TYPE_DICT:
 		d.FieldArray("items", func(d *decode.D) {
 			end := false
 			for !end {
 				d.FieldStruct("key", func(d *decode.D) {
-					ty := r_object(d)
+					ty, _ := r_object(d, ctx)
 					end = ty == TYPE_NULL
 				})
 				if end {
 					break
 				}
 				d.FieldStruct("value", func(d *decode.D) {
-					ty := r_object(d)
+					ty, _ := r_object(d, ctx)
 					end = ty == TYPE_NULL
 				})
 			}
@@ -207,7 +306,7 @@ func r_object(d *decode.D) uint64 {
 		fallthrough
 	case TYPE_FROZENSET:
 		n := d.FieldS32("n")
-		read_list(d, int64(n))
+		value = read_list(d, ctx, int64(n))
 
 	case TYPE_CODE:
 		d.FieldS32("argcount")
@@ -215,37 +314,113 @@ func r_object(d *decode.D) uint64 {
 		d.FieldS32("kwonlyargcount")
 		d.FieldS32("stacksize")
 		d.FieldS32("flags")
-		d.FieldStruct("code", func(d *decode.D) { r_object(d) })
-		d.FieldStruct("consts", func(d *decode.D) { r_object(d) })
-		d.FieldStruct("names", func(d *decode.D) { r_object(d) })
-		d.FieldStruct("localsplusnames", func(d *decode.D) { r_object(d) })
-		d.FieldStruct("localspluskinds", func(d *decode.D) { r_object(d) })
-		d.FieldStruct("filename", func(d *decode.D) { r_object(d) })
-		d.FieldStruct("name", func(d *decode.D) { r_object(d) })
-		d.FieldStruct("qualname", func(d *decode.D) { r_object(d) })
-		d.FieldU32("firstlineno")
-		d.FieldStruct("linetable", func(d *decode.D) { r_object(d) })
-		d.FieldStruct("exceptiontable", func(d *decode.D) { r_object(d) })
+
+		var code []byte
+		d.FieldStruct("code", func(d *decode.D) {
+			_, v := r_object(d, ctx)
+			code, _ = v.([]byte)
+		})
+
+		var consts, names, localsplusnames []any
+		d.FieldStruct("consts", func(d *decode.D) {
+			_, v := r_object(d, ctx)
+			consts, _ = v.([]any)
+		})
+		d.FieldStruct("names", func(d *decode.D) {
+			_, v := r_object(d, ctx)
+			names, _ = v.([]any)
+		})
+		d.FieldStruct("localsplusnames", func(d *decode.D) {
+			_, v := r_object(d, ctx)
+			localsplusnames, _ = v.([]any)
+		})
+		d.FieldStruct("localspluskinds", func(d *decode.D) { r_object(d, ctx) })
+		d.FieldStruct("filename", func(d *decode.D) { r_object(d, ctx) })
+		d.FieldStruct("name", func(d *decode.D) { r_object(d, ctx) })
+		d.FieldStruct("qualname", func(d *decode.D) { r_object(d, ctx) })
+		firstlineno := d.FieldU32("firstlineno")
+
+		var linetable []byte
+		d.FieldStruct("linetable", func(d *decode.D) {
+			_, v := r_object(d, ctx)
+			linetable, _ = v.([]byte)
+		})
+		var exceptiontable []byte
+		d.FieldStruct("exceptiontable", func(d *decode.D) {
+			_, v := r_object(d, ctx)
+			exceptiontable, _ = v.([]byte)
+		})
+
+		decodeInstructions(d, ctx, code, consts, names, localsplusnames)
+		if ctx.pyMinor >= 10 {
+			decodeLineTable(d, linetable, int64(firstlineno))
+		}
+		if ctx.pyMinor >= 11 {
+			decodeExceptionTable(d, exceptiontable)
+		}
 
 	case TYPE_REF:
-		d.FieldU32("index")
+		index := d.FieldU32("index")
+		if int(index) < len(ctx.refs) {
+			target := ctx.refs[index]
+			d.FieldValueU("target_pos", uint64(target.pos))
+			if entry, ok := typeMap[target.ty]; ok {
+				d.FieldValueStr("target_type", entry.Sym)
+			}
+			value = target.value
+		}
 
 	case TYPE_SLICE:
-		panic("slice not implemented")
+		d.FieldStruct("start", func(d *decode.D) { r_object(d, ctx) })
+		d.FieldStruct("stop", func(d *decode.D) { r_object(d, ctx) })
+		d.FieldStruct("step", func(d *decode.D) { r_object(d, ctx) })
+	}
+
+	if refIndex >= 0 {
+		ctx.refs[refIndex].value = value
 	}
 
-	return ty
+	return ty, value
 }
 
 func decodePYC(d *decode.D) any {
 	d.Endian = decode.LittleEndian
 
-	d.FieldU32("magic", scalar.UintHex)
-	d.FieldRawLen("bit field", 4*8)
-	d.FieldU32("timestamp", scalar.UintActualUnixTimeDescription(time.Second, time.RFC3339))
-	d.FieldU32("length")
+	magic := d.FieldU32("magic", scalar.UintHex)
+
+	ctx := &decodeCtx{pyMinor: 12} // fall back to the newest supported opcode table
+	if version, minor, ok := pythonVersionForMagic(magic); ok {
+		ctx.pyVersion = version
+		ctx.pyMinor = minor
+		d.FieldValueStr("python_version", version)
+	} else {
+		d.FieldValueStr("python_version", "unknown")
+	}
+
+	// PEP 552 (Python 3.7+) turns the second header word into a flags
+	// field: bit 0 selects a hash-based pyc (source_hash instead of
+	// timestamp/length), bit 1 says whether the interpreter checks it.
+	if ctx.pyVersion != "" && ctx.pyMinor >= 7 {
+		hashBased := false
+		d.FieldStruct("flags", func(d *decode.D) {
+			flags := d.FieldU32("raw", scalar.UintHex)
+			hashBased = flags&0b1 != 0
+			d.FieldValueBool("hash_based", hashBased)
+			d.FieldValueBool("checked", flags&0b10 != 0)
+		})
+		if hashBased {
+			d.FieldU64("source_hash", scalar.UintHex)
+		} else {
+			d.FieldU32("timestamp", scalar.UintActualUnixTimeDescription(time.Second, time.RFC3339))
+			d.FieldU32("length")
+		}
+	} else {
+		d.FieldRawLen("bit field", 4*8)
+		d.FieldU32("timestamp", scalar.UintActualUnixTimeDescription(time.Second, time.RFC3339))
+		d.FieldU32("length")
+	}
 
-	d.FieldStruct("object", func(d *decode.D) { r_object(d) })
+	d.FieldStruct("object", func(d *decode.D) { r_object(d, ctx) })
 
 	return nil
 }
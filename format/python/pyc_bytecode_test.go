@@ -0,0 +1,55 @@
+package pyc
+
+import "testing"
+
+// TestResolveArgLoadAttrVersionGate guards against the LOAD_ATTR/LOAD_METHOD
+// name-index shift being applied outside the Python version it actually
+// applies to: the is-method bit was only packed into LOAD_ATTR's arg from
+// 3.12, when LOAD_METHOD was folded into it.
+func TestResolveArgLoadAttrVersionGate(t *testing.T) {
+	names := []any{"foo", "bar"}
+
+	t.Run("3.11 LOAD_ATTR takes the raw name index", func(t *testing.T) {
+		got, ok := resolveArg("LOAD_ATTR", 1, 11, nil, names, nil)
+		if !ok || got != "bar" {
+			t.Errorf("resolveArg = (%v, %v), want (bar, true)", got, ok)
+		}
+	})
+
+	t.Run("3.11 LOAD_METHOD takes the raw name index", func(t *testing.T) {
+		got, ok := resolveArg("LOAD_METHOD", 1, 11, nil, names, nil)
+		if !ok || got != "bar" {
+			t.Errorf("resolveArg = (%v, %v), want (bar, true)", got, ok)
+		}
+	})
+
+	t.Run("3.12 LOAD_ATTR shifts off the is-method bit", func(t *testing.T) {
+		got, ok := resolveArg("LOAD_ATTR", 2, 12, nil, names, nil)
+		if !ok || got != "bar" {
+			t.Errorf("resolveArg = (%v, %v), want (bar, true)", got, ok)
+		}
+	})
+
+	t.Run("3.11 LOAD_GLOBAL always shifts off the push-null bit", func(t *testing.T) {
+		got, ok := resolveArg("LOAD_GLOBAL", 2, 11, nil, names, nil)
+		if !ok || got != "bar" {
+			t.Errorf("resolveArg = (%v, %v), want (bar, true)", got, ok)
+		}
+	})
+}
+
+// TestOpcodeTableForVersion guards against mislabeling pre-3.11 opcodes
+// with 3.11/3.12 numbering, which isn't compatible.
+func TestOpcodeTableForVersion(t *testing.T) {
+	if got := opcodeTableForVersion(11); len(got) == 0 {
+		t.Error("3.11 should resolve to a populated opcode table")
+	}
+	if got := opcodeTableForVersion(12); len(got) == 0 {
+		t.Error("3.12 should resolve to a populated opcode table")
+	}
+	for _, minor := range []int{6, 7, 8, 9, 10} {
+		if got := opcodeTableForVersion(minor); len(got) != 0 {
+			t.Errorf("3.%d should resolve to an empty (unresolved) opcode table, got %d entries", minor, len(got))
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package pyc
+
+// co_exceptiontable format (PEP 657): https://github.com/python/cpython/blob/main/Objects/exception_handling_notes.txt
+
+import (
+	"github.com/wader/fq/pkg/decode"
+)
+
+// readExceptionVarint decodes one exception-table varint: 6 data bits per
+// byte (bit 6 is the continuation flag), accumulated big-endian (the
+// first byte read holds the most significant bits). The very first byte
+// of a record also has bit 7 set as a "start of record" marker, but that
+// bit falls outside the 6-bit data mask and so doesn't need separate
+// handling here. See dis._parse_varint in the standard library.
+func readExceptionVarint(b []byte, off *int) uint64 {
+	if *off >= len(b) {
+		return 0
+	}
+	by := b[*off]
+	*off++
+	val := uint64(by & 0x3F)
+	for by&0x40 != 0 {
+		if *off >= len(b) {
+			break
+		}
+		by = b[*off]
+		*off++
+		val = val<<6 | uint64(by&0x3F)
+	}
+	return val
+}
+
+// decodeExceptionTable disassembles the compact co_exceptiontable bytes
+// into (start, end, target, depth, lasti) handler entries, expressed in
+// bytecode byte offsets.
+func decodeExceptionTable(d *decode.D, table []byte) {
+	d.FieldArray("exception_entries", func(d *decode.D) {
+		off := 0
+		for off < len(table) {
+			start := readExceptionVarint(table, &off)
+			length := readExceptionVarint(table, &off)
+			target := readExceptionVarint(table, &off)
+			depthAndLasti := readExceptionVarint(table, &off)
+
+			d.FieldStruct("entry", func(d *decode.D) {
+				d.FieldValueU("start", start*2)
+				d.FieldValueU("end", (start+length)*2)
+				d.FieldValueU("target", target*2)
+				d.FieldValueU("depth", depthAndLasti>>1)
+				d.FieldValueBool("lasti", depthAndLasti&1 != 0)
+			})
+		}
+	})
+}
@@ -0,0 +1,84 @@
+package pyc
+
+import "testing"
+
+// TestReadExceptionVarint exercises the co_exceptiontable varint format
+// (6 data bits per byte, bit 6 as continuation, big-endian accumulation)
+// against hand-packed bytes, per dis._parse_varint / CPython's
+// Objects/exception_handling_notes.txt.
+func TestReadExceptionVarint(t *testing.T) {
+	t.Run("single byte, start-of-record marker ignored", func(t *testing.T) {
+		// Bit 7 (0x80) marks the start of a table entry but isn't part of
+		// the varint's value, which is masked to the low 6 bits.
+		table := []byte{0x82}
+		off := 0
+		if got := readExceptionVarint(table, &off); got != 2 {
+			t.Errorf("readExceptionVarint = %d, want 2", got)
+		}
+		if off != 1 {
+			t.Errorf("off = %d, want 1", off)
+		}
+	})
+
+	t.Run("multi-byte, big-endian accumulation", func(t *testing.T) {
+		// 100 split into 6-bit chunks big-endian: high chunk 1 (continuation
+		// set), low chunk 36.
+		table := []byte{0x41, 0x24}
+		off := 0
+		if got := readExceptionVarint(table, &off); got != 100 {
+			t.Errorf("readExceptionVarint = %d, want 100", got)
+		}
+		if off != 2 {
+			t.Errorf("off = %d, want 2", off)
+		}
+	})
+}
+
+// TestDecodeExceptionTableEntries decodes a table for two handler entries
+// and checks the byte-offset math CPython's own exception table parser
+// produces for the same bytes.
+func TestDecodeExceptionTableEntries(t *testing.T) {
+	type entry struct {
+		start, end, target, depth uint64
+		lasti                     bool
+	}
+	var got []entry
+
+	table := []byte{
+		// entry 1: start=2, length=16, target=19, depth=0, lasti=false
+		// -> start=4, end=36, target=38
+		0x82, 0x10, 0x13, 0x00,
+		// entry 2: start=0, length=1, target=100 (multi-byte), depth=1, lasti=true
+		// -> start=0, end=2, target=200
+		0x80, 0x01, 0x41, 0x24, 0x03,
+	}
+
+	off := 0
+	for off < len(table) {
+		start := readExceptionVarint(table, &off)
+		length := readExceptionVarint(table, &off)
+		target := readExceptionVarint(table, &off)
+		depthAndLasti := readExceptionVarint(table, &off)
+		got = append(got, entry{
+			start:  start * 2,
+			end:    (start + length) * 2,
+			target: target * 2,
+			depth:  depthAndLasti >> 1,
+			lasti:  depthAndLasti&1 != 0,
+		})
+	}
+
+	want := []entry{
+		{start: 4, end: 36, target: 38, depth: 0, lasti: false},
+		{start: 0, end: 2, target: 200, depth: 1, lasti: true},
+	}
+
+	if len(got) != len(want) {
+		t.Fatalf("got %d entries, want %d: %+v", len(got), len(want), got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("entry %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
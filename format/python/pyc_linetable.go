@@ -0,0 +1,148 @@
+package pyc
+
+// co_linetable format (PEP 626): https://github.com/python/cpython/blob/main/Objects/locations.md
+
+import (
+	"github.com/wader/fq/pkg/decode"
+)
+
+const (
+	locationShortFormMax = 9  // codes 0-9: same line, column range in following bytes
+	locationOneLineMax   = 12 // codes 10-12: line_delta = code-10, columns as two raw bytes
+	locationNoColumn     = 13 // code 13: signed varint line delta, no column info
+	locationLongForm     = 14 // code 14: line/end-line/column deltas as varints
+)
+
+// readVarint decodes an unsigned little-endian-ish varint: 7 bits per
+// byte, continuation bit (0x80) set on every byte but the last.
+func readVarint(b []byte, off *int) uint64 {
+	val := uint64(0)
+	shift := uint(0)
+	for *off < len(b) {
+		by := b[*off]
+		*off++
+		val |= uint64(by&0x7F) << shift
+		shift += 7
+		if by&0x80 == 0 {
+			break
+		}
+	}
+	return val
+}
+
+// readSignedVarint decodes a varint whose bit 0 carries the sign, as
+// used for the line delta in the location table.
+func readSignedVarint(b []byte, off *int) int64 {
+	uval := readVarint(b, off)
+	if uval&1 != 0 {
+		return -int64(uval >> 1)
+	}
+	return int64(uval >> 1)
+}
+
+// lineTableEntry is one decoded (bytecode_start, bytecode_end, line_start,
+// line_end, col_start, col_end) row of the PEP 626 location table.
+type lineTableEntry struct {
+	bytecodeStart, bytecodeEnd int64
+	lineStart, lineEnd         int64
+	colStart, colEnd           int64
+}
+
+// parseLineTable decodes the PEP 626 co_linetable bytes into entries. Kept
+// separate from decodeLineTable so the byte-level parsing can be unit
+// tested without a *decode.D.
+func parseLineTable(table []byte, firstLine int64) []lineTableEntry {
+	var entries []lineTableEntry
+	bytecodeOffset := int64(0)
+	line := firstLine
+
+	off := 0
+	for off < len(table) {
+		marker := table[off]
+		off++
+		if marker&0x80 == 0 {
+			// Not a valid entry start, stop rather than misparse the rest.
+			break
+		}
+
+		code := uint64(marker>>3) & 0xF
+		length := int64(marker&0x7) + 1
+
+		var lineStart, lineEnd, colStart, colEnd int64
+
+		switch {
+		case code <= locationShortFormMax:
+			// Short form: same line, column range packed into one more
+			// raw byte (not a varint): column = code*8 + bits 4-6,
+			// end_column = column + low nibble.
+			lineStart, lineEnd = line, line
+			if off >= len(table) {
+				break
+			}
+			b := table[off]
+			off++
+			colStart = int64(code)*8 + int64(b>>4&0x7)
+			colEnd = colStart + int64(b&0xF)
+
+		case code <= locationOneLineMax:
+			// One-line form: line delta is baked into the code, columns
+			// follow as two raw bytes (not varints).
+			line += int64(code) - 10
+			lineStart, lineEnd = line, line
+			if off+1 >= len(table) {
+				off = len(table)
+				break
+			}
+			colStart = int64(table[off])
+			colEnd = int64(table[off+1])
+			off += 2
+
+		case code == locationNoColumn:
+			// No column info: only a signed varint line delta.
+			line += readSignedVarint(table, &off)
+			lineStart, lineEnd = line, line
+			colStart, colEnd = -1, -1
+
+		case code == locationLongForm:
+			line += readSignedVarint(table, &off)
+			lineStart = line
+			lineEnd = line + int64(readVarint(table, &off))
+			colStart = int64(readVarint(table, &off))
+			colEnd = int64(readVarint(table, &off))
+
+		default:
+			lineStart, lineEnd = line, line
+		}
+
+		entries = append(entries, lineTableEntry{
+			bytecodeStart: bytecodeOffset * 2,
+			bytecodeEnd:   (bytecodeOffset + length) * 2,
+			lineStart:     lineStart,
+			lineEnd:       lineEnd,
+			colStart:      colStart,
+			colEnd:        colEnd,
+		})
+
+		bytecodeOffset += length
+	}
+
+	return entries
+}
+
+// decodeLineTable disassembles the PEP 626 co_linetable bytes into
+// (bytecode_start, bytecode_end, line_start, line_end, col_start,
+// col_end) entries. Only valid for the 3.10+ location table format.
+func decodeLineTable(d *decode.D, table []byte, firstLine int64) {
+	d.FieldArray("line_table", func(d *decode.D) {
+		for _, e := range parseLineTable(table, firstLine) {
+			d.FieldStruct("entry", func(d *decode.D) {
+				d.FieldValueU("bytecode_start", uint64(e.bytecodeStart))
+				d.FieldValueU("bytecode_end", uint64(e.bytecodeEnd))
+				d.FieldValueS("line_start", e.lineStart)
+				d.FieldValueS("line_end", e.lineEnd)
+				d.FieldValueS("col_start", e.colStart)
+				d.FieldValueS("col_end", e.colEnd)
+			})
+		}
+	})
+}
@@ -0,0 +1,83 @@
+package pyc
+
+import "testing"
+
+// TestParseLineTable exercises each PEP 626 location table form against
+// hand-packed bytes matching CPython's actual encoding (see
+// write_location_info_short_form/_one_line/_no_column/_long_form in
+// Python/compile.c), to guard against byte-consumption desyncs.
+func TestParseLineTable(t *testing.T) {
+	marker := func(code uint64, length int) byte {
+		return 0x80 | byte(code<<3) | byte(length-1)
+	}
+
+	t.Run("short form", func(t *testing.T) {
+		// code 3, length 1, column packed into one extra byte:
+		// column = 3*8 + 5 = 29, end_column = 29 + 9 = 38.
+		table := []byte{marker(3, 1), 0x59}
+		entries := parseLineTable(table, 10)
+		if len(entries) != 1 {
+			t.Fatalf("got %d entries, want 1", len(entries))
+		}
+		e := entries[0]
+		if e.lineStart != 10 || e.lineEnd != 10 {
+			t.Errorf("line = (%d, %d), want (10, 10)", e.lineStart, e.lineEnd)
+		}
+		if e.colStart != 29 || e.colEnd != 38 {
+			t.Errorf("col = (%d, %d), want (29, 38)", e.colStart, e.colEnd)
+		}
+	})
+
+	t.Run("one line form", func(t *testing.T) {
+		// code 11 (line_delta = 1), length 2, columns as two raw bytes.
+		table := []byte{marker(11, 2), 4, 12}
+		entries := parseLineTable(table, 10)
+		if len(entries) != 1 {
+			t.Fatalf("got %d entries, want 1", len(entries))
+		}
+		e := entries[0]
+		if e.lineStart != 11 || e.lineEnd != 11 {
+			t.Errorf("line = (%d, %d), want (11, 11)", e.lineStart, e.lineEnd)
+		}
+		if e.colStart != 4 || e.colEnd != 12 {
+			t.Errorf("col = (%d, %d), want (4, 12)", e.colStart, e.colEnd)
+		}
+	})
+
+	t.Run("no column form", func(t *testing.T) {
+		// code 13, length 1, signed varint line delta of +2 (encoded as 4).
+		table := []byte{marker(13, 1), 0x04}
+		entries := parseLineTable(table, 10)
+		if len(entries) != 1 {
+			t.Fatalf("got %d entries, want 1", len(entries))
+		}
+		e := entries[0]
+		if e.lineStart != 12 || e.lineEnd != 12 {
+			t.Errorf("line = (%d, %d), want (12, 12)", e.lineStart, e.lineEnd)
+		}
+		if e.colStart != -1 || e.colEnd != -1 {
+			t.Errorf("col = (%d, %d), want (-1, -1)", e.colStart, e.colEnd)
+		}
+	})
+
+	t.Run("stays in sync across entries", func(t *testing.T) {
+		// A short-form entry followed by a one-line-form entry: if the
+		// short form over- or under-consumes bytes, the second entry's
+		// marker byte is misread and this desyncs.
+		table := []byte{
+			marker(3, 1), 0x59, // short form, as above
+			marker(11, 1), 4, 12, // one-line form, as above
+		}
+		entries := parseLineTable(table, 10)
+		if len(entries) != 2 {
+			t.Fatalf("got %d entries, want 2", len(entries))
+		}
+		if entries[1].lineStart != 11 {
+			t.Errorf("second entry line = %d, want 11 (table desynced)", entries[1].lineStart)
+		}
+		if entries[1].colStart != 4 || entries[1].colEnd != 12 {
+			t.Errorf("second entry col = (%d, %d), want (4, 12) (table desynced)",
+				entries[1].colStart, entries[1].colEnd)
+		}
+	})
+}
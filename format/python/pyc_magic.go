@@ -0,0 +1,40 @@
+package pyc
+
+// Magic numbers: https://github.com/python/cpython/blob/main/Lib/importlib/_bootstrap_external.py
+// (MAGIC_NUMBER changes on essentially every feature release, so this is a
+// best-effort table of the final magic number for each 3.x release.)
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/wader/fq/pkg/scalar"
+)
+
+// magicVersionMap maps a pyc magic number (the little-endian uint32 made
+// up of the 16-bit release number followed by \r\n) to the CPython
+// version that produced it.
+var magicVersionMap = scalar.UintMap{
+	0x0A0D0D33: {Sym: "3.6.0", Description: "CPython 3.6"},
+	0x0A0D0D42: {Sym: "3.7.0", Description: "CPython 3.7"},
+	0x0A0D0D55: {Sym: "3.8.0", Description: "CPython 3.8"},
+	0x0A0D0D61: {Sym: "3.9.0", Description: "CPython 3.9"},
+	0x0A0D0D6F: {Sym: "3.10.0", Description: "CPython 3.10"},
+	0x0A0D0DA7: {Sym: "3.11.0", Description: "CPython 3.11"},
+	0x0A0D0DCB: {Sym: "3.12.0", Description: "CPython 3.12"},
+}
+
+// pythonVersionForMagic resolves a magic number to a symbolic version
+// string and the minor version number, for callers that need to branch
+// on it (e.g. picking an opcode table).
+func pythonVersionForMagic(magic uint64) (version string, minor int, ok bool) {
+	entry, ok := magicVersionMap[magic]
+	if !ok {
+		return "", 0, false
+	}
+	parts := strings.SplitN(entry.Sym, ".", 3)
+	if len(parts) >= 2 {
+		minor, _ = strconv.Atoi(parts[1])
+	}
+	return entry.Sym, minor, true
+}